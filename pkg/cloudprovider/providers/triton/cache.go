@@ -0,0 +1,137 @@
+package triton
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	triton "github.com/joyent/triton-go"
+)
+
+// defaultCacheTTL is used when Config.Global.CacheTTL is unset or invalid.
+const defaultCacheTTL = 30 * time.Second
+
+// machineLister is the subset of triton-go's Machines client machineCache
+// depends on, so tests can drive it with a fake instead of a real CloudAPI
+// connection.
+type machineLister interface {
+	ListMachines(ctx context.Context, input *triton.ListMachinesInput) ([]*triton.Machine, error)
+}
+
+// machineCache maintains an in-memory, indexed snapshot of the account's
+// machines so that per-node lookups (by UUID, name, hostname, or primary IP)
+// don't need to walk a ListMachines response -- and pay its latency and
+// per-machine error risk -- on every kubelet/controller-manager call.
+// It refreshes itself on a timer, and can additionally be refreshed on demand
+// when a lookup misses.
+type machineCache struct {
+	client machineLister
+	ttl    time.Duration
+
+	mu         sync.RWMutex
+	byUUID     map[string]*triton.Machine
+	byName     map[string]*triton.Machine
+	byHostname map[string]*triton.Machine
+	byIP       map[string]*triton.Machine
+}
+
+// newMachineCache constructs a machineCache and performs an initial
+// synchronous refresh, so that callers never observe an empty cache, before
+// starting its background refresh goroutine.
+func newMachineCache(client machineLister, ttl time.Duration) (*machineCache, error) {
+	if ttl <= 0 {
+		ttl = defaultCacheTTL
+	}
+
+	c := &machineCache{
+		client: client,
+		ttl:    ttl,
+	}
+	if err := c.refresh(); err != nil {
+		return nil, err
+	}
+
+	go c.run()
+
+	return c, nil
+}
+
+// run periodically refreshes the cache until the process exits.
+func (c *machineCache) run() {
+	ticker := time.NewTicker(c.ttl)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := c.refresh(); err != nil {
+			glog.Errorf("machineCache: periodic refresh failed: %s", err)
+		}
+	}
+}
+
+// refresh lists every machine in the account and rebuilds the indexes.
+func (c *machineCache) refresh() error {
+	input := &triton.ListMachinesInput{}
+	machines, err := c.client.ListMachines(context.Background(), input)
+	if err != nil {
+		return err
+	}
+
+	byUUID := make(map[string]*triton.Machine, len(machines))
+	byName := make(map[string]*triton.Machine, len(machines))
+	byHostname := make(map[string]*triton.Machine, len(machines))
+	byIP := make(map[string]*triton.Machine, len(machines))
+
+	for _, machine := range machines {
+		byUUID[machine.ID] = machine
+		byName[machine.Name] = machine
+		byHostname[machine.Name] = machine
+		if machine.PrimaryIP != "" {
+			byIP[machine.PrimaryIP] = machine
+		}
+	}
+
+	c.mu.Lock()
+	c.byUUID, c.byName, c.byHostname, c.byIP = byUUID, byName, byHostname, byIP
+	c.mu.Unlock()
+
+	return nil
+}
+
+func (c *machineCache) getByUUID(uuid string) (*triton.Machine, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	m, ok := c.byUUID[uuid]
+	return m, ok
+}
+
+func (c *machineCache) getByName(name string) (*triton.Machine, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	m, ok := c.byName[name]
+	return m, ok
+}
+
+func (c *machineCache) getByHostname(hostname string) (*triton.Machine, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	m, ok := c.byHostname[hostname]
+	return m, ok
+}
+
+func (c *machineCache) getByIP(ip string) (*triton.Machine, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	m, ok := c.byIP[ip]
+	return m, ok
+}
+
+// all returns every machine currently in the cache.
+func (c *machineCache) all() []*triton.Machine {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	machines := make([]*triton.Machine, 0, len(c.byUUID))
+	for _, m := range c.byUUID {
+		machines = append(machines, m)
+	}
+	return machines
+}
@@ -0,0 +1,90 @@
+package triton
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	triton "github.com/joyent/triton-go"
+)
+
+// fakeMachineLister is a machineLister backed by a fixed slice of machines,
+// counting how many times ListMachines was called so tests can assert a
+// cache miss triggers exactly one refresh.
+type fakeMachineLister struct {
+	machines []*triton.Machine
+	calls    int
+}
+
+func (f *fakeMachineLister) ListMachines(ctx context.Context, input *triton.ListMachinesInput) ([]*triton.Machine, error) {
+	f.calls++
+	return f.machines, nil
+}
+
+func testMachines() []*triton.Machine {
+	return []*triton.Machine{
+		{ID: "uuid-1", Name: "node-1", PrimaryIP: "10.0.0.1"},
+		{ID: "uuid-2", Name: "node-2", PrimaryIP: "10.0.0.2"},
+	}
+}
+
+func TestMachineCacheIndexes(t *testing.T) {
+	lister := &fakeMachineLister{machines: testMachines()}
+	cache, err := newMachineCache(lister, time.Hour)
+	if err != nil {
+		t.Fatalf("newMachineCache() returned an error: %s", err)
+	}
+
+	if _, ok := cache.getByUUID("uuid-1"); !ok {
+		t.Errorf("expected uuid-1 to be indexed by UUID")
+	}
+	if _, ok := cache.getByName("node-2"); !ok {
+		t.Errorf("expected node-2 to be indexed by name")
+	}
+	if _, ok := cache.getByHostname("node-2"); !ok {
+		t.Errorf("expected node-2 to be indexed by hostname")
+	}
+	if _, ok := cache.getByIP("10.0.0.1"); !ok {
+		t.Errorf("expected 10.0.0.1 to be indexed by IP")
+	}
+	if _, ok := cache.getByUUID("does-not-exist"); ok {
+		t.Errorf("did not expect a hit for an unknown UUID")
+	}
+	if len(cache.all()) != 2 {
+		t.Errorf("all() = %d machines, want 2", len(cache.all()))
+	}
+}
+
+// TestMachineCacheRefreshOnMiss exercises getMachineByName's index-miss path:
+// a lookup that misses the cache should trigger exactly one refresh, and
+// should then find a machine that only appeared after the cache was first
+// populated.
+func TestMachineCacheRefreshOnMiss(t *testing.T) {
+	lister := &fakeMachineLister{machines: testMachines()}
+	cache, err := newMachineCache(lister, time.Hour)
+	if err != nil {
+		t.Fatalf("newMachineCache() returned an error: %s", err)
+	}
+	if lister.calls != 1 {
+		t.Fatalf("expected exactly one ListMachines call from the initial refresh, got %d", lister.calls)
+	}
+
+	instances := Instances{provider: &Triton{MachineCache: cache}}
+
+	if _, err := instances.getMachineByName("node-3"); err == nil {
+		t.Errorf("getMachineByName() should fail for a machine that doesn't exist yet")
+	}
+	if lister.calls != 2 {
+		t.Errorf("expected a cache miss to trigger exactly one refresh, got %d ListMachines calls", lister.calls)
+	}
+
+	lister.machines = append(lister.machines, &triton.Machine{ID: "uuid-3", Name: "node-3"})
+
+	machine, err := instances.getMachineByName("node-3")
+	if err != nil {
+		t.Fatalf("getMachineByName() returned an error: %s", err)
+	}
+	if machine.ID != "uuid-3" {
+		t.Errorf("getMachineByName(\"node-3\") = %s, want uuid-3", machine.ID)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"regexp"
 
 	"github.com/golang/glog"
 	triton "github.com/joyent/triton-go"
@@ -21,62 +22,75 @@ type Instances struct {
 // -----------------------------------------------------------------------------
 //
 
-// getMachineByUUID returns the triton.Machine for a given UUID
+// getMachineByUUID returns the triton.Machine for a given UUID. It prefers a
+// direct CloudAPI GetMachine (a single request by UUID) and only falls back
+// to the machine cache -- which may be briefly stale -- if that request
+// fails, e.g. because of a transient CloudAPI error.
 func (i Instances) getMachineByUUID(uuid string) (*triton.Machine, error) {
 	input := &triton.GetMachineInput{uuid}
 	machine, err := i.provider.Client.Machines().GetMachine(context.Background(), input)
-	if err != nil {
-		glog.Errorf("Machines.GetMachine() returned an error: %s", err)
-		return nil, err
+	if err == nil {
+		return machine, nil
+	}
+	glog.Errorf("Machines.GetMachine() returned an error: %s", err)
+
+	if cached, ok := i.provider.MachineCache.getByUUID(uuid); ok {
+		return cached, nil
 	}
-	return machine, nil
+	return nil, err
 }
 
-// getMachineByName searches through all machines within a Triton account
-// looking for a match by passed in serverName string. Checks (within order)
-// PrimaryIP, Hostname, and UUID.
+// getMachineByName looks up a machine by the passed in serverName string,
+// checking (in order) PrimaryIP, Name, and UUID against the machine
+// cache. On a miss the cache is refreshed once, in case the machine was
+// created since the last refresh, before giving up.
 func (i Instances) getMachineByName(serverName string) (*triton.Machine, error) {
-	input := &triton.ListMachinesInput{}
-	machines, err := i.provider.Client.Machines().ListMachines(context.Background(), input)
-	if err != nil {
-		glog.Errorf("Machines.ListMachines() returned an error: %s", err)
-		return nil, err
+	if machine, ok := i.lookupByName(serverName); ok {
+		return machine, nil
 	}
 
-	for _, machine := range machines {
-		if machine.PrimaryIP == serverName {
-			return machine, nil
-		}
-		if machine.Name == serverName {
-			return machine, nil
-		}
-		// TODO: make this a short UUID match
-		if machine.ID == serverName {
-			return machine, nil
-		}
+	if err := i.provider.MachineCache.refresh(); err != nil {
+		glog.Errorf("machineCache.refresh() returned an error: %s", err)
+		return nil, err
 	}
 
+	if machine, ok := i.lookupByName(serverName); ok {
+		return machine, nil
+	}
 	return nil, fmt.Errorf("No machine found by serverName: %s", serverName)
 }
 
-// getMachineByHostname searches through all machines within a Triton account
-// looking for a match by passed in hostname string. Checks only hostname.
+// lookupByName consults the machine cache's indexes for serverName, in the
+// same PrimaryIP, Name, UUID precedence getMachineByName has always used.
+func (i Instances) lookupByName(serverName string) (*triton.Machine, bool) {
+	if machine, ok := i.provider.MachineCache.getByIP(serverName); ok {
+		return machine, true
+	}
+	if machine, ok := i.provider.MachineCache.getByName(serverName); ok {
+		return machine, true
+	}
+	// TODO: make this a short UUID match
+	if machine, ok := i.provider.MachineCache.getByUUID(serverName); ok {
+		return machine, true
+	}
+	return nil, false
+}
+
+// getMachineByHostname looks up a machine by the passed in hostname string
+// against the machine cache's hostname index, refreshing the cache once on a
+// miss before giving up.
 func (i Instances) getMachineByHostname(hostname string) (*triton.Machine, error) {
-	input := &triton.ListMachinesInput{}
-	machines, err := i.provider.Client.Machines().ListMachines(context.Background(), input)
-	if err != nil {
-		glog.Errorf("Machines.ListMachines() returned an error: %s", err)
+	if machine, ok := i.provider.MachineCache.getByHostname(hostname); ok {
+		return machine, nil
+	}
+
+	if err := i.provider.MachineCache.refresh(); err != nil {
+		glog.Errorf("machineCache.refresh() returned an error: %s", err)
 		return nil, err
 	}
 
-	for _, machine := range machines {
-		if machine.Name == hostname {
-			return machine, nil
-		}
-		// TODO: make this a short UUID match
-		if machine.ID == hostname {
-			return machine, nil
-		}
+	if machine, ok := i.provider.MachineCache.getByHostname(hostname); ok {
+		return machine, nil
 	}
 	return nil, fmt.Errorf("No machine found by hostname: %s", hostname)
 }
@@ -194,14 +208,22 @@ func (i *Instances) NodeAddresses(name types.NodeName) ([]api.NodeAddress, error
 // ("", cloudprovider.InstanceNotFound)
 func (i *Instances) ExternalID(name types.NodeName) (string, error) {
 	glog.V(2).Infof("Instances.ExternalID() called with %s", name)
-	return i.probeMachineUUID(string(name))
+	uuid, err := i.probeMachineUUID(string(name))
+	if err != nil {
+		return "", err
+	}
+	return providerIDPrefix + uuid, nil
 }
 
 // InstanceID returns the cloud provider ID of the node with the specified
 // NodeName.
 func (i *Instances) InstanceID(name types.NodeName) (string, error) {
 	glog.V(2).Infof("Instances.InstanceID() called with %s", name)
-	return i.probeMachineUUID(string(name))
+	uuid, err := i.probeMachineUUID(string(name))
+	if err != nil {
+		return "", err
+	}
+	return providerIDPrefix + uuid, nil
 }
 
 // InstanceType returns the type of the specified instance.
@@ -215,16 +237,19 @@ func (i *Instances) InstanceType(name types.NodeName) (string, error) {
 func (i *Instances) List(filter string) ([]types.NodeName, error) {
 	glog.V(2).Infof("Instances.List() called with %s", filter)
 
-	input := &triton.ListMachinesInput{}
-	machines, err := i.provider.Client.Machines().ListMachines(context.Background(), input)
+	re, err := regexp.Compile("^(?:" + filter + ")$")
 	if err != nil {
-		glog.Errorf("Triton.Instances() returned an error: %s", err)
+		glog.Errorf("Instances.List() given an invalid filter regex: %s", err)
 		return nil, err
 	}
 
-	names := make([]types.NodeName, len(machines))
+	machines := i.provider.MachineCache.all()
+
+	names := make([]types.NodeName, 0, len(machines))
 	for _, machine := range machines {
-		names = append(names, types.NodeName(machine.ID))
+		if re.MatchString(machine.Name) {
+			names = append(names, types.NodeName(machine.ID))
+		}
 	}
 	return names, nil
 }
@@ -0,0 +1,41 @@
+package triton
+
+import (
+	"testing"
+	"time"
+
+	triton "github.com/joyent/triton-go"
+)
+
+// TestInstancesList regression-tests two bugs: List used to return a slice
+// of length 2*len(machines) (make(..., len(machines)) followed by append),
+// and it ignored the filter regex entirely.
+func TestInstancesList(t *testing.T) {
+	lister := &fakeMachineLister{machines: []*triton.Machine{
+		{ID: "uuid-1", Name: "node-1"},
+		{ID: "uuid-2", Name: "node-2"},
+		{ID: "uuid-3", Name: "other-3"},
+	}}
+	cache, err := newMachineCache(lister, time.Hour)
+	if err != nil {
+		t.Fatalf("newMachineCache() returned an error: %s", err)
+	}
+
+	instances := &Instances{provider: &Triton{MachineCache: cache}}
+
+	names, err := instances.List("node-.*")
+	if err != nil {
+		t.Fatalf("List() returned an error: %s", err)
+	}
+	if len(names) != 2 {
+		t.Fatalf("List(\"node-.*\") returned %d names, want 2: %v", len(names), names)
+	}
+
+	all, err := instances.List(".*")
+	if err != nil {
+		t.Fatalf("List() returned an error: %s", err)
+	}
+	if len(all) != 3 {
+		t.Errorf("List(\".*\") returned %d names, want 3: %v", len(all), all)
+	}
+}
@@ -0,0 +1,291 @@
+package triton
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	triton "github.com/joyent/triton-go"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/cloudprovider"
+)
+
+// cnsServiceTag is the machine metadata tag Triton CNS watches to build a
+// service's DNS records. Its value is a comma-separated list of service
+// names the machine should be advertised under.
+const cnsServiceTag = "triton.cns.services"
+
+// defaultCNSSuffix is used when Config.Global.CNSSuffix is unset, matching
+// the Joyent public cloud's CNS zone.
+const defaultCNSSuffix = "cns.joyent.com"
+
+// machineMetadataSetter is the subset of triton-go's Machines client
+// tagMachines/setCNSServices depends on, so tests can drive it with a fake
+// instead of a real CloudAPI connection.
+type machineMetadataSetter interface {
+	UpdateMachineMetadata(ctx context.Context, input *triton.UpdateMachineMetadataInput) (*triton.ComputeMetadata, error)
+}
+
+// firewallRuleClient is the subset of triton-go's FirewallRules client
+// ensureFirewallRules/deleteFirewallRules depends on, so tests can drive it
+// with a fake instead of a real CloudAPI connection.
+type firewallRuleClient interface {
+	ListFirewallRules(ctx context.Context, input *triton.ListFirewallRulesInput) ([]*triton.FirewallRule, error)
+	Create(ctx context.Context, input *triton.CreateFirewallRuleInput) (*triton.FirewallRule, error)
+	Delete(ctx context.Context, input *triton.DeleteFirewallRuleInput) error
+}
+
+// LoadBalancers implements cloudprovider.LoadBalancer for Service type=
+// LoadBalancer on top of Triton CNS, for service discovery, and Triton
+// firewall rules, for exposing the service's port to its source ranges.
+// There is no separate load-balancing tier: CNS round-robins DNS across the
+// backend machines directly.
+type LoadBalancers struct {
+	provider *Triton
+
+	// machines and firewallRules default to provider.Client's own clients;
+	// tests override them with fakes so EnsureLoadBalancer can be exercised
+	// without a real CloudAPI connection.
+	machines      machineMetadataSetter
+	firewallRules firewallRuleClient
+}
+
+// LoadBalancer returns an implementation of cloudprovider.LoadBalancer for
+// Triton.
+func (t *Triton) LoadBalancer() (cloudprovider.LoadBalancer, bool) {
+	glog.V(2).Info("Triton.LoadBalancer() called")
+
+	return &LoadBalancers{
+		provider:      t,
+		machines:      t.Client.Machines(),
+		firewallRules: t.Client.FirewallRules(),
+	}, true
+}
+
+// GetLoadBalancer returns the status of the load balancer for service, if
+// any machine is currently tagged for it.
+func (lb *LoadBalancers) GetLoadBalancer(clusterName string, service *api.Service) (*api.LoadBalancerStatus, bool, error) {
+	name := cloudprovider.GetLoadBalancerName(service)
+	glog.V(2).Infof("LoadBalancers.GetLoadBalancer() called for %s", name)
+
+	for _, machine := range lb.provider.MachineCache.all() {
+		if cnsServicesOf(machine)[name] {
+			return lb.status(name), true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// EnsureLoadBalancer tags service's backend nodes with a Triton CNS service
+// tag and opens firewall rules admitting the service's ports from its
+// configured source ranges (or the world, by default).
+func (lb *LoadBalancers) EnsureLoadBalancer(clusterName string, service *api.Service, nodes []*api.Node) (*api.LoadBalancerStatus, error) {
+	name := cloudprovider.GetLoadBalancerName(service)
+	glog.V(2).Infof("LoadBalancers.EnsureLoadBalancer() called for %s", name)
+
+	if len(service.Spec.Ports) == 0 {
+		return nil, fmt.Errorf("EnsureLoadBalancer: service %s has no ports", name)
+	}
+
+	if err := lb.tagMachines(name, nodes); err != nil {
+		return nil, err
+	}
+	if err := lb.ensureFirewallRules(name, service); err != nil {
+		return nil, err
+	}
+
+	return lb.status(name), nil
+}
+
+// UpdateLoadBalancer re-tags service's backend machines to match nodes,
+// removing the CNS service tag from any machine that dropped out of the set.
+func (lb *LoadBalancers) UpdateLoadBalancer(clusterName string, service *api.Service, nodes []*api.Node) error {
+	name := cloudprovider.GetLoadBalancerName(service)
+	glog.V(2).Infof("LoadBalancers.UpdateLoadBalancer() called for %s", name)
+
+	return lb.tagMachines(name, nodes)
+}
+
+// EnsureLoadBalancerDeleted removes service's CNS service tag from every
+// machine and deletes the firewall rules it owns.
+func (lb *LoadBalancers) EnsureLoadBalancerDeleted(clusterName string, service *api.Service) error {
+	name := cloudprovider.GetLoadBalancerName(service)
+	glog.V(2).Infof("LoadBalancers.EnsureLoadBalancerDeleted() called for %s", name)
+
+	if err := lb.tagMachines(name, nil); err != nil {
+		return err
+	}
+	return lb.deleteFirewallRules(name)
+}
+
+// status builds the LoadBalancerStatus pointing at service's CNS DNS name.
+func (lb *LoadBalancers) status(name string) *api.LoadBalancerStatus {
+	return &api.LoadBalancerStatus{
+		Ingress: []api.LoadBalancerIngress{
+			{Hostname: lb.cnsHostname(name)},
+		},
+	}
+}
+
+// cnsHostname returns the CNS DNS name a tagged service is reachable at:
+// <name>.svc.<account>.<datacenter>.<cns-suffix>.
+func (lb *LoadBalancers) cnsHostname(name string) string {
+	return fmt.Sprintf("%s.svc.%s.%s.%s",
+		name, lb.provider.AccountName, lb.provider.Metadata.Datacenter, lb.provider.CNSSuffix)
+}
+
+// cnsServicesOf parses the comma-separated cnsServiceTag value on machine
+// into a set of service names.
+func cnsServicesOf(machine *triton.Machine) map[string]bool {
+	services := map[string]bool{}
+	for _, svc := range strings.Split(machine.Tags[cnsServiceTag], ",") {
+		svc = strings.TrimSpace(svc)
+		if svc != "" {
+			services[svc] = true
+		}
+	}
+	return services
+}
+
+// tagMachines adds the CNS service tag name to every machine backing nodes,
+// and removes it from any other machine currently carrying it, so a machine
+// dropped from the node set (cordoned, drained, deleted) stops being
+// advertised for the service.
+func (lb *LoadBalancers) tagMachines(name string, nodes []*api.Node) error {
+	wantUUIDs := make(map[string]bool, len(nodes))
+	instances := Instances{provider: lb.provider}
+	for _, node := range nodes {
+		machine, err := instances.getMachineByName(node.Name)
+		if err != nil {
+			glog.Errorf("LoadBalancers: could not resolve node %s to a machine: %s", node.Name, err)
+			return err
+		}
+		wantUUIDs[machine.ID] = true
+	}
+
+	for _, machine := range lb.provider.MachineCache.all() {
+		services := cnsServicesOf(machine)
+		has, want := services[name], wantUUIDs[machine.ID]
+		if has == want {
+			continue
+		}
+
+		if want {
+			services[name] = true
+		} else {
+			delete(services, name)
+		}
+		if err := lb.setCNSServices(machine.ID, services); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setCNSServices writes services back to machineID's cnsServiceTag metadata.
+func (lb *LoadBalancers) setCNSServices(machineID string, services map[string]bool) error {
+	names := make([]string, 0, len(services))
+	for svc := range services {
+		names = append(names, svc)
+	}
+	sort.Strings(names)
+
+	input := &triton.UpdateMachineMetadataInput{
+		ID: machineID,
+		Metadata: map[string]string{
+			cnsServiceTag: strings.Join(names, ","),
+		},
+	}
+	_, err := lb.machines.UpdateMachineMetadata(context.Background(), input)
+	return err
+}
+
+// ensureFirewallRules opens name's backend machines to each of service's
+// ports, from service's LoadBalancerSourceRanges (or 0.0.0.0/0 if unset).
+//
+// EnsureLoadBalancer is called on every Service Add/Update resync, not just
+// once at creation, so this must be idempotent: it lists the rules already
+// tagged for name and skips ports that are already correctly opened, instead
+// of blindly creating a new ALLOW rule every time. A rule whose ports match
+// but whose source ranges no longer do (LoadBalancerSourceRanges changed) is
+// deleted and recreated rather than left behind as a stale duplicate.
+func (lb *LoadBalancers) ensureFirewallRules(name string, service *api.Service) error {
+	sources := service.Spec.LoadBalancerSourceRanges
+	if len(sources) == 0 {
+		sources = []string{"0.0.0.0/0"}
+	}
+
+	existing, err := lb.firewallRules.ListFirewallRules(context.Background(), &triton.ListFirewallRulesInput{})
+	if err != nil {
+		return err
+	}
+	tagClause := fmt.Sprintf("tag %q = %q", cnsServiceTag, name)
+
+	for _, port := range service.Spec.Ports {
+		portClause := fmt.Sprintf("ALLOW %s PORT %d", strings.ToLower(string(port.Protocol)), port.Port)
+		rule := fmt.Sprintf("FROM (%s) TO %s %s", fromClause(sources), tagClause, portClause)
+
+		current := false
+		for _, r := range existing {
+			if !strings.Contains(r.Rule, tagClause) || !strings.HasSuffix(r.Rule, " "+portClause) {
+				continue
+			}
+			if r.Rule == rule {
+				current = true
+				continue
+			}
+			if err := lb.firewallRules.Delete(context.Background(), &triton.DeleteFirewallRuleInput{ID: r.ID}); err != nil {
+				return err
+			}
+		}
+		if current {
+			continue
+		}
+
+		input := &triton.CreateFirewallRuleInput{
+			Rule:    rule,
+			Enabled: true,
+		}
+		if _, err := lb.firewallRules.Create(context.Background(), input); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteFirewallRules removes every firewall rule ensureFirewallRules created
+// for name.
+func (lb *LoadBalancers) deleteFirewallRules(name string) error {
+	rules, err := lb.firewallRules.ListFirewallRules(context.Background(), &triton.ListFirewallRulesInput{})
+	if err != nil {
+		return err
+	}
+
+	tagClause := fmt.Sprintf("tag %q = %q", cnsServiceTag, name)
+	for _, rule := range rules {
+		if !strings.Contains(rule.Rule, tagClause) {
+			continue
+		}
+		input := &triton.DeleteFirewallRuleInput{ID: rule.ID}
+		if err := lb.firewallRules.Delete(context.Background(), input); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fromClause renders sources as a triton firewall rule FROM clause, using
+// the "subnet" keyword for CIDRs and "ip" for bare addresses.
+func fromClause(sources []string) string {
+	parts := make([]string, len(sources))
+	for i, s := range sources {
+		if strings.Contains(s, "/") {
+			parts[i] = fmt.Sprintf("subnet %s", s)
+		} else {
+			parts[i] = fmt.Sprintf("ip %s", s)
+		}
+	}
+	return strings.Join(parts, " OR ")
+}
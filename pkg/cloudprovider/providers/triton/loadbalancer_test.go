@@ -0,0 +1,117 @@
+package triton
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	triton "github.com/joyent/triton-go"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+// fakeMachineMetadataSetter is a machineMetadataSetter backed by the same
+// machines a fakeMachineLister was constructed from, applying metadata
+// updates in place so a test can observe their effect through MachineCache.
+type fakeMachineMetadataSetter struct {
+	machines map[string]*triton.Machine
+}
+
+func (f *fakeMachineMetadataSetter) UpdateMachineMetadata(ctx context.Context, input *triton.UpdateMachineMetadataInput) (*triton.ComputeMetadata, error) {
+	machine, ok := f.machines[input.ID]
+	if !ok {
+		return nil, fmt.Errorf("no such machine: %s", input.ID)
+	}
+	if machine.Tags == nil {
+		machine.Tags = map[string]string{}
+	}
+	for k, v := range input.Metadata {
+		machine.Tags[k] = v
+	}
+	return nil, nil
+}
+
+// fakeFirewallRuleClient is a firewallRuleClient backed by an in-memory rule
+// set, counting Create calls so a test can assert ensureFirewallRules doesn't
+// create a duplicate rule on a resync.
+type fakeFirewallRuleClient struct {
+	rules   []*triton.FirewallRule
+	nextID  int
+	creates int
+}
+
+func (f *fakeFirewallRuleClient) ListFirewallRules(ctx context.Context, input *triton.ListFirewallRulesInput) ([]*triton.FirewallRule, error) {
+	return f.rules, nil
+}
+
+func (f *fakeFirewallRuleClient) Create(ctx context.Context, input *triton.CreateFirewallRuleInput) (*triton.FirewallRule, error) {
+	f.creates++
+	f.nextID++
+	rule := &triton.FirewallRule{
+		ID:      fmt.Sprintf("rule-%d", f.nextID),
+		Rule:    input.Rule,
+		Enabled: input.Enabled,
+	}
+	f.rules = append(f.rules, rule)
+	return rule, nil
+}
+
+func (f *fakeFirewallRuleClient) Delete(ctx context.Context, input *triton.DeleteFirewallRuleInput) error {
+	for i, r := range f.rules {
+		if r.ID == input.ID {
+			f.rules = append(f.rules[:i], f.rules[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("no such rule: %s", input.ID)
+}
+
+// TestEnsureLoadBalancerIdempotent regression-tests the bug fixed in
+// ensureFirewallRules: calling EnsureLoadBalancer twice for the same service
+// used to create a second, duplicate ALLOW rule on every resync instead of
+// recognizing the rule it had already created.
+func TestEnsureLoadBalancerIdempotent(t *testing.T) {
+	machine := &triton.Machine{ID: "uuid-1", Name: "node-1"}
+	lister := &fakeMachineLister{machines: []*triton.Machine{machine}}
+	cache, err := newMachineCache(lister, time.Hour)
+	if err != nil {
+		t.Fatalf("newMachineCache() returned an error: %s", err)
+	}
+
+	lb := &LoadBalancers{
+		provider: &Triton{
+			MachineCache: cache,
+			AccountName:  "testuser",
+			CNSSuffix:    defaultCNSSuffix,
+			Metadata:     &Metadata{Datacenter: "test-dc"},
+		},
+		machines:      &fakeMachineMetadataSetter{machines: map[string]*triton.Machine{machine.ID: machine}},
+		firewallRules: &fakeFirewallRuleClient{},
+	}
+
+	service := &api.Service{
+		ObjectMeta: api.ObjectMeta{Name: "my-svc", Namespace: "default"},
+		Spec: api.ServiceSpec{
+			Ports: []api.ServicePort{{Protocol: api.ProtocolTCP, Port: 80}},
+		},
+	}
+	nodes := []*api.Node{{ObjectMeta: api.ObjectMeta{Name: "node-1"}}}
+
+	if _, err := lb.EnsureLoadBalancer("test-cluster", service, nodes); err != nil {
+		t.Fatalf("EnsureLoadBalancer() (1st call) returned an error: %s", err)
+	}
+	fw := lb.firewallRules.(*fakeFirewallRuleClient)
+	if fw.creates != 1 {
+		t.Fatalf("expected exactly one firewall rule created by the first call, got %d", fw.creates)
+	}
+
+	if _, err := lb.EnsureLoadBalancer("test-cluster", service, nodes); err != nil {
+		t.Fatalf("EnsureLoadBalancer() (2nd call) returned an error: %s", err)
+	}
+	if fw.creates != 1 {
+		t.Errorf("EnsureLoadBalancer() should be idempotent: second call made %d total creates, want 1", fw.creates)
+	}
+	if len(fw.rules) != 1 {
+		t.Errorf("expected exactly one firewall rule to exist after two EnsureLoadBalancer calls, got %d", len(fw.rules))
+	}
+}
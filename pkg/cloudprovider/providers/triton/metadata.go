@@ -0,0 +1,200 @@
+package triton
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// defaultMdataGetPath is the canonical location of the mdata-get client
+// inside an LX-brand zone or full SmartOS guest.
+const defaultMdataGetPath = "/usr/sbin/mdata-get"
+
+// defaultMdataTimeout bounds each individual metadata request, whether it's
+// an mdata-get invocation or an HTTP round-trip.
+const defaultMdataTimeout = 400 * time.Millisecond
+
+// defaultMetadataURL is the Triton Instance Metadata HTTP API, reachable via
+// the in-guest socket-proxy on every Triton VM/container brand -- not just
+// ones that ship mdata-get.
+const defaultMetadataURL = "http://169.254.169.254/metadata/v1"
+
+// MetadataSource abstracts how we learn a node's own Triton instance
+// metadata, so newTriton isn't hard-wired to shelling out to mdata-get.
+type MetadataSource interface {
+	GetMetadata() (*Metadata, error)
+}
+
+// defaultMetadataSource picks an MdataGetSource when the configured (or
+// default) mdata-get binary exists, and an HTTPMetadataSource otherwise --
+// e.g. inside a KVM/bhyve guest, or an LX-brand zone built without it.
+func defaultMetadataSource(cfg Config) MetadataSource {
+	path := cfg.Global.MdataGetPath
+	if path == "" {
+		path = defaultMdataGetPath
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		return NewMdataGetSource(cfg)
+	}
+	return NewHTTPMetadataSource(cfg)
+}
+
+// mdataTimeout returns cfg.Global.MdataTimeout parsed as a duration, or
+// defaultMdataTimeout if it's unset or invalid.
+func mdataTimeout(cfg Config) time.Duration {
+	if d, err := time.ParseDuration(cfg.Global.MdataTimeout); err == nil {
+		return d
+	}
+	return defaultMdataTimeout
+}
+
+//
+// -----------------------------------------------------------------------------
+//
+
+// MdataGetSource reads instance metadata by shelling out to the mdata-get
+// client, as SmartOS and LX-brand zones provide.
+type MdataGetSource struct {
+	path    string
+	timeout time.Duration
+}
+
+// NewMdataGetSource constructs an MdataGetSource from cfg, applying
+// defaultMdataGetPath/defaultMdataTimeout where cfg leaves them unset.
+func NewMdataGetSource(cfg Config) *MdataGetSource {
+	path := cfg.Global.MdataGetPath
+	if path == "" {
+		path = defaultMdataGetPath
+	}
+
+	return &MdataGetSource{
+		path:    path,
+		timeout: mdataTimeout(cfg),
+	}
+}
+
+// get runs "mdata-get <key>" and returns its trimmed stdout.
+func (s *MdataGetSource) get(key string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.path, key)
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("mdata-get %s: %s", key, err)
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// GetMetadata implements MetadataSource.
+func (s *MdataGetSource) GetMetadata() (*Metadata, error) {
+	uuid, err := s.get("sdc:uuid")
+	if err != nil {
+		return nil, err
+	}
+	hostname, err := s.get("sdc:hostname")
+	if err != nil {
+		return nil, err
+	}
+	datacenter, err := s.get("sdc:datacenter_name")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Metadata{
+		UUID:       uuid,
+		Hostname:   hostname,
+		Datacenter: datacenter,
+	}, nil
+}
+
+//
+// -----------------------------------------------------------------------------
+//
+
+// HTTPMetadataSource reads instance metadata from the Triton Instance
+// Metadata HTTP API instead of shelling out to mdata-get, so it also works
+// from KVM/bhyve guests and LX-brand zones that don't ship the client.
+type HTTPMetadataSource struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewHTTPMetadataSource constructs an HTTPMetadataSource from cfg, applying
+// defaultMetadataURL/defaultMdataTimeout where cfg leaves them unset.
+func NewHTTPMetadataSource(cfg Config) *HTTPMetadataSource {
+	baseURL := cfg.Global.MetadataURL
+	if baseURL == "" {
+		baseURL = defaultMetadataURL
+	}
+
+	return &HTTPMetadataSource{
+		baseURL: baseURL,
+		client:  &http.Client{Timeout: mdataTimeout(cfg)},
+	}
+}
+
+// get fetches <baseURL>/<key> and returns its trimmed body.
+func (s *HTTPMetadataSource) get(key string) (string, error) {
+	resp, err := s.client.Get(s.baseURL + "/" + key)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GET %s/%s: %s", s.baseURL, key, resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// GetMetadata implements MetadataSource.
+func (s *HTTPMetadataSource) GetMetadata() (*Metadata, error) {
+	uuid, err := s.get("sdc:uuid")
+	if err != nil {
+		return nil, err
+	}
+	hostname, err := s.get("sdc:hostname")
+	if err != nil {
+		return nil, err
+	}
+	datacenter, err := s.get("sdc:datacenter_name")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Metadata{
+		UUID:       uuid,
+		Hostname:   hostname,
+		Datacenter: datacenter,
+	}, nil
+}
+
+//
+// -----------------------------------------------------------------------------
+//
+
+// fakeMetadataSource is a MetadataSource backed by a fixed Metadata/error
+// pair, letting tests exercise newTriton without root or a SmartOS zone.
+type fakeMetadataSource struct {
+	metadata *Metadata
+	err      error
+}
+
+// GetMetadata implements MetadataSource.
+func (s *fakeMetadataSource) GetMetadata() (*Metadata, error) {
+	return s.metadata, s.err
+}
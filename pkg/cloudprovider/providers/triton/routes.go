@@ -0,0 +1,177 @@
+package triton
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/golang/glog"
+	triton "github.com/joyent/triton-go"
+	"k8s.io/kubernetes/pkg/cloudprovider"
+	"k8s.io/kubernetes/pkg/types"
+)
+
+// podCIDRTag is the machine metadata tag Routes uses to record a node's
+// assigned pod CIDR, so ListRoutes can reconstruct routes without keeping any
+// extra state of its own.
+const podCIDRTag = "k8s.pod-cidr"
+
+// Routes implements cloudprovider.Routes by programming per-node pod CIDR
+// routes onto a single, pre-existing Triton fabric network (Config.Global.
+// FabricNetwork), instead of requiring an overlay network such as flannel or
+// weave. The configured account/subuser must own that fabric: both attaching
+// a NIC to it and editing its route table require fabric-owner permissions.
+type Routes struct {
+	provider *Triton
+}
+
+// Routes returns an implementation of cloudprovider.Routes for Triton, or
+// (nil, false) if no FabricNetwork is configured to carry pod traffic.
+func (t *Triton) Routes() (cloudprovider.Routes, bool) {
+	glog.V(2).Info("Triton.Routes() called")
+
+	if t.FabricNetwork == "" {
+		return nil, false
+	}
+	return &Routes{
+		provider: t,
+	}, true
+}
+
+// ListRoutes lists every pod CIDR route currently tagged on a machine.
+func (r *Routes) ListRoutes(clusterName string) ([]*cloudprovider.Route, error) {
+	glog.V(2).Infof("Routes.ListRoutes() called for %s", clusterName)
+
+	var routes []*cloudprovider.Route
+	for _, machine := range r.provider.MachineCache.all() {
+		cidr := machine.Tags[podCIDRTag]
+		if cidr == "" {
+			continue
+		}
+		routes = append(routes, &cloudprovider.Route{
+			Name:            fmt.Sprintf("%s-%s", clusterName, machine.ID),
+			TargetNode:      types.NodeName(machine.ID),
+			DestinationCIDR: cidr,
+		})
+	}
+	return routes, nil
+}
+
+// CreateRoute tags route.TargetNode with its pod CIDR and points the fabric
+// network's route table at the node's fabric NIC as next-hop.
+func (r *Routes) CreateRoute(clusterName, nameHint string, route *cloudprovider.Route) error {
+	glog.V(2).Infof("Routes.CreateRoute() called for %s (%s)", route.TargetNode, route.DestinationCIDR)
+
+	machine, err := r.machineForNode(route.TargetNode)
+	if err != nil {
+		return err
+	}
+
+	nextHop, err := r.fabricNIC(machine)
+	if err != nil {
+		return err
+	}
+
+	if err := r.setPodCIDR(machine.ID, route.DestinationCIDR); err != nil {
+		return err
+	}
+	return r.setFabricRoute(route.DestinationCIDR, nextHop)
+}
+
+// DeleteRoute clears route.TargetNode's pod CIDR tag and removes its entry
+// from the fabric network's route table.
+func (r *Routes) DeleteRoute(clusterName string, route *cloudprovider.Route) error {
+	glog.V(2).Infof("Routes.DeleteRoute() called for %s (%s)", route.TargetNode, route.DestinationCIDR)
+
+	machine, err := r.machineForNode(route.TargetNode)
+	if err != nil {
+		return err
+	}
+
+	if err := r.setPodCIDR(machine.ID, ""); err != nil {
+		return err
+	}
+	return r.clearFabricRoute(route.DestinationCIDR)
+}
+
+// machineForNode resolves a Route's TargetNode to its backing machine.
+func (r *Routes) machineForNode(name types.NodeName) (*triton.Machine, error) {
+	instances := Instances{provider: r.provider}
+	return instances.getMachineByName(string(name))
+}
+
+// fabricNIC returns the IP of machine's NIC on the configured fabric
+// network, attaching one if the machine doesn't already have it.
+func (r *Routes) fabricNIC(machine *triton.Machine) (string, error) {
+	for _, nic := range machine.NICs {
+		if nic.Network == r.provider.FabricNetwork {
+			return nic.IP, nil
+		}
+	}
+
+	input := &triton.AddNICInput{
+		MachineID: machine.ID,
+		Network:   r.provider.FabricNetwork,
+	}
+	nic, err := r.provider.Client.Machines().AddNIC(context.Background(), input)
+	if err != nil {
+		return "", err
+	}
+	return nic.IP, nil
+}
+
+// setPodCIDR writes (or, given an empty cidr, clears) the pod CIDR tag on
+// machineID.
+func (r *Routes) setPodCIDR(machineID, cidr string) error {
+	input := &triton.UpdateMachineMetadataInput{
+		ID: machineID,
+		Metadata: map[string]string{
+			podCIDRTag: cidr,
+		},
+	}
+	_, err := r.provider.Client.Machines().UpdateMachineMetadata(context.Background(), input)
+	return err
+}
+
+// setFabricRoute adds or updates the fabric network's static route for cidr,
+// via nextHop.
+func (r *Routes) setFabricRoute(cidr, nextHop string) error {
+	network, err := r.provider.Client.Fabrics().GetNetwork(context.Background(), &triton.GetFabricNetworkInput{
+		ID: r.provider.FabricNetwork,
+	})
+	if err != nil {
+		return err
+	}
+
+	routes := network.Routes
+	if routes == nil {
+		routes = map[string]string{}
+	}
+	routes[cidr] = nextHop
+
+	_, err = r.provider.Client.Fabrics().UpdateNetwork(context.Background(), &triton.UpdateFabricNetworkInput{
+		ID:     r.provider.FabricNetwork,
+		Routes: routes,
+	})
+	return err
+}
+
+// clearFabricRoute removes cidr from the fabric network's static routes.
+func (r *Routes) clearFabricRoute(cidr string) error {
+	network, err := r.provider.Client.Fabrics().GetNetwork(context.Background(), &triton.GetFabricNetworkInput{
+		ID: r.provider.FabricNetwork,
+	})
+	if err != nil {
+		return err
+	}
+
+	if network.Routes == nil {
+		return nil
+	}
+	delete(network.Routes, cidr)
+
+	_, err = r.provider.Client.Fabrics().UpdateNetwork(context.Background(), &triton.UpdateFabricNetworkInput{
+		ID:     r.provider.FabricNetwork,
+		Routes: network.Routes,
+	})
+	return err
+}
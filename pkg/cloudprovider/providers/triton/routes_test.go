@@ -0,0 +1,45 @@
+package triton
+
+import (
+	"testing"
+	"time"
+
+	triton "github.com/joyent/triton-go"
+	"k8s.io/kubernetes/pkg/types"
+)
+
+// TestListRoutes exercises the podCIDRTag round-trip ListRoutes relies on:
+// untagged machines are skipped, and a tagged machine's route is rebuilt from
+// its ID and tag value alone.
+func TestListRoutes(t *testing.T) {
+	machines := []*triton.Machine{
+		{ID: "uuid-1", Name: "node-1", Tags: map[string]string{podCIDRTag: "10.244.0.0/24"}},
+		{ID: "uuid-2", Name: "node-2"},
+	}
+	lister := &fakeMachineLister{machines: machines}
+	cache, err := newMachineCache(lister, time.Hour)
+	if err != nil {
+		t.Fatalf("newMachineCache() returned an error: %s", err)
+	}
+
+	r := &Routes{provider: &Triton{MachineCache: cache}}
+
+	routes, err := r.ListRoutes("test-cluster")
+	if err != nil {
+		t.Fatalf("ListRoutes() returned an error: %s", err)
+	}
+	if len(routes) != 1 {
+		t.Fatalf("ListRoutes() returned %d routes, want 1: %v", len(routes), routes)
+	}
+
+	route := routes[0]
+	if route.TargetNode != types.NodeName("uuid-1") {
+		t.Errorf("ListRoutes()[0].TargetNode = %s, want uuid-1", route.TargetNode)
+	}
+	if route.DestinationCIDR != "10.244.0.0/24" {
+		t.Errorf("ListRoutes()[0].DestinationCIDR = %s, want 10.244.0.0/24", route.DestinationCIDR)
+	}
+	if route.Name != "test-cluster-uuid-1" {
+		t.Errorf("ListRoutes()[0].Name = %s, want test-cluster-uuid-1", route.Name)
+	}
+}
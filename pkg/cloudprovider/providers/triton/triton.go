@@ -17,13 +17,11 @@ limitations under the License.
 package triton
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
-	"os/exec"
+	"os"
 	"time"
 
 	"github.com/golang/glog"
@@ -36,15 +34,24 @@ import (
 
 const ProviderName = "triton"
 
+// providerIDPrefix is prepended to a machine's UUID to form the
+// cloudprovider-facing provider ID, e.g. "triton://<uuid>".
+const providerIDPrefix = "triton://"
+
 type Triton struct {
-	Client   *triton.Client
-	Metadata *Metadata
-	Instance *triton.Machine
+	Client        *triton.Client
+	Metadata      *Metadata
+	Instance      *triton.Machine
+	MachineCache  *machineCache
+	AccountName   string
+	CNSSuffix     string
+	FabricNetwork string
 }
 
 type Metadata struct {
-	UUID     string
-	Hostname string
+	UUID       string
+	Hostname   string
+	Datacenter string
 }
 
 type Config struct {
@@ -53,6 +60,44 @@ type Config struct {
 		KeyPath     string `gcfg:"key-path"`
 		EndpointURL string `gcfg:"endpoint-url"`
 		AccountName string `gcfg:"account"`
+
+		// User, if set, delegates CloudAPI signing to a Triton RBAC subuser
+		// rather than the master account named in AccountName.
+		User string `gcfg:"user"`
+
+		// UseSSHAgent selects SSH-agent signing instead of reading a private
+		// key off disk. It is also implied when KeyPath is unset and
+		// SSH_AUTH_SOCK is present in the environment.
+		UseSSHAgent bool `gcfg:"use-ssh-agent"`
+
+		// CacheTTL controls how often the machine cache backing Instances is
+		// refreshed, e.g. "30s". Defaults to defaultCacheTTL.
+		CacheTTL string `gcfg:"cache-ttl"`
+
+		// CNSSuffix overrides the DNS suffix LoadBalancer status hostnames are
+		// built from. Defaults to defaultCNSSuffix; private-cloud Triton
+		// installs will typically need to set this.
+		CNSSuffix string `gcfg:"cns-suffix"`
+
+		// FabricNetwork is the UUID of the Triton fabric network that pod
+		// traffic is routed over. Routes() is unavailable unless this is set.
+		// The configured account (or subuser, see User) must be the fabric's
+		// owner, since programming routes and attaching NICs both require
+		// fabric-owner permissions.
+		FabricNetwork string `gcfg:"fabric-network"`
+
+		// MdataGetPath overrides the path to the mdata-get binary used by
+		// MdataGetSource. Defaults to defaultMdataGetPath.
+		MdataGetPath string `gcfg:"mdata-get-path"`
+
+		// MdataTimeout overrides the per-request timeout used by both
+		// MdataGetSource and HTTPMetadataSource, e.g. "400ms". Defaults to
+		// defaultMdataTimeout.
+		MdataTimeout string `gcfg:"mdata-timeout"`
+
+		// MetadataURL overrides the base URL HTTPMetadataSource queries.
+		// Defaults to defaultMetadataURL.
+		MetadataURL string `gcfg:"metadata-url"`
 	}
 }
 
@@ -64,7 +109,7 @@ func init() {
 			if err != nil {
 				return nil, err
 			}
-			return newTriton(cfg)
+			return newTriton(cfg, defaultMetadataSource(cfg), cloudAPILocalhostLookup)
 		})
 }
 
@@ -80,74 +125,106 @@ func readConfig(config io.Reader) (Config, error) {
 	return cfg, err
 }
 
-// initMetadata returns a Metadata object initialized by shelling out the the
-// `mdata-get` client.
-//
-// TODO: Right now this is mandatory because the rest of the API will require
-// the host UUID.
-func initMetadata() (*Metadata, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 400*time.Millisecond)
-	defer cancel()
-	uuid, err := exec.CommandContext(ctx, "/usr/sbin/mdata-get", "sdc:uuid").Output()
-	if err != nil {
-		return nil, err
+// signerFromConfig builds the authentication.Signer CloudAPI requests are
+// signed with. Exactly one of SSH-agent or private-key-on-disk mode may be
+// selected; SSH-agent mode is assumed when KeyPath is empty and SSH_AUTH_SOCK
+// is present, so operators are not forced to carry unencrypted key material.
+// Global.User, if set, is passed through as the signer's Username so the
+// provider authenticates as a Triton RBAC subuser instead of the master
+// account -- letting the master account's key stay off of the kubelet/
+// controller-manager entirely.
+func signerFromConfig(cfg Config) (authentication.Signer, error) {
+	useSSHAgent := cfg.Global.UseSSHAgent
+	if !useSSHAgent && cfg.Global.KeyPath == "" && os.Getenv("SSH_AUTH_SOCK") != "" {
+		useSSHAgent = true
 	}
 
-	var (
-		hname string
-		out   bytes.Buffer
-	)
-	ctx2, cancel2 := context.WithTimeout(context.Background(), 400*time.Millisecond)
-	defer cancel2()
-	cmd := exec.CommandContext(ctx2, "/usr/sbin/mdata-get", "sdc:hostname")
-	cmd.Stdout = &out
-	err = cmd.Run()
-	if err == nil {
-		hname = string(out.Bytes())
-	} else {
-		hname = string(uuid)
+	if useSSHAgent && cfg.Global.KeyPath != "" {
+		return nil, fmt.Errorf("newTriton: use-ssh-agent and key-path are mutually exclusive")
 	}
 
-	return &Metadata{
-		UUID:     string(uuid),
-		Hostname: string(hname),
-	}, nil
-}
+	if useSSHAgent {
+		return authentication.NewSSHAgentSigner(authentication.SSHAgentSignerInput{
+			KeyID:       cfg.Global.KeyID,
+			AccountName: cfg.Global.AccountName,
+			Username:    cfg.Global.User,
+		})
+	}
 
-// newTriton constructs a new Triton object with our client as it's provider
-func newTriton(cfg Config) (*Triton, error) {
 	privateKey, err := ioutil.ReadFile(cfg.Global.KeyPath)
 	if err != nil {
 		glog.Error("newTriton: could not access configured KeyPath")
 		return nil, err
 	}
 
-	sshKeySigner, err := authentication.NewPrivateKeySigner(cfg.Global.KeyID, privateKey,
-		cfg.Global.AccountName)
+	return authentication.NewPrivateKeySigner(authentication.PrivateKeySignerInput{
+		KeyID:              cfg.Global.KeyID,
+		PrivateKeyMaterial: privateKey,
+		AccountName:        cfg.Global.AccountName,
+		Username:           cfg.Global.User,
+	})
+}
+
+// localhostLookup resolves the node's own triton.Machine record given its
+// UUID, abstracting the CloudAPI GetMachine call so newTriton is testable
+// without a live CloudAPI connection.
+type localhostLookup func(client *triton.Client, uuid string) (*triton.Machine, error)
+
+// cloudAPILocalhostLookup is the production localhostLookup, backed by a
+// real CloudAPI GetMachine request.
+func cloudAPILocalhostLookup(client *triton.Client, uuid string) (*triton.Machine, error) {
+	input := &triton.GetMachineInput{uuid}
+	return client.Machines().GetMachine(context.Background(), input)
+}
+
+// newTriton constructs a new Triton object with our client as it's provider.
+// source supplies the node's own instance metadata (UUID, hostname,
+// datacenter); lookupLocalhost resolves that UUID to a triton.Machine. Pass
+// defaultMetadataSource(cfg)/cloudAPILocalhostLookup in production, or fakes
+// in tests that don't have root, a real SmartOS zone, or CloudAPI access.
+func newTriton(cfg Config, source MetadataSource, lookupLocalhost localhostLookup) (*Triton, error) {
+	signer, err := signerFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := triton.NewClient(cfg.Global.EndpointURL, cfg.Global.AccountName, signer)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("newTriton: NewClient: %s", err)
 	}
 
-	client, err := triton.NewClient(cfg.Global.EndpointURL, cfg.Global.AccountName, sshKeySigner)
+	metadata, err := source.GetMetadata()
 	if err != nil {
-		log.Fatalf("NewClient: %s", err)
+		return nil, fmt.Errorf("newTriton: could not determine instance metadata: %s", err)
 	}
 
-	metadata, err := initMetadata()
+	localhost, err := lookupLocalhost(client, metadata.UUID)
 	if err != nil {
-		log.Fatalf("initMetadata: %s", err)
+		return nil, fmt.Errorf("newTriton: could not look up localhost machine: %s", err)
 	}
 
-	input := &triton.GetMachineInput{metadata.UUID}
-	localhost, err := client.Machines().GetMachine(context.Background(), input)
+	ttl, err := time.ParseDuration(cfg.Global.CacheTTL)
+	if err != nil {
+		ttl = defaultCacheTTL
+	}
+	machineCache, err := newMachineCache(client.Machines(), ttl)
 	if err != nil {
-		log.Fatalf("GetMachineInput for localhost: %s", err)
+		return nil, fmt.Errorf("newMachineCache: %s", err)
+	}
+
+	cnsSuffix := cfg.Global.CNSSuffix
+	if cnsSuffix == "" {
+		cnsSuffix = defaultCNSSuffix
 	}
 
 	return &Triton{
-		Client:   client,
-		Metadata: metadata,
-		Instance: localhost,
+		Client:        client,
+		Metadata:      metadata,
+		Instance:      localhost,
+		MachineCache:  machineCache,
+		AccountName:   cfg.Global.AccountName,
+		CNSSuffix:     cnsSuffix,
+		FabricNetwork: cfg.Global.FabricNetwork,
 	}, nil
 }
 
@@ -162,22 +239,7 @@ func (t *Triton) ScrubDNS(nameservers, searches []string) (nsOut, srchOut []stri
 	return nameservers, searches
 }
 
-// LoadBalancer is just a stub
-func (t *Triton) LoadBalancer() (cloudprovider.LoadBalancer, bool) {
-	return nil, false
-}
-
 // Clusters is just a stub
 func (t *Triton) Clusters() (cloudprovider.Clusters, bool) {
 	return nil, false
 }
-
-// Zones is just a stub
-func (t *Triton) Zones() (cloudprovider.Zones, bool) {
-	return nil, false
-}
-
-// Routes is just a stub
-func (t *Triton) Routes() (cloudprovider.Routes, bool) {
-	return nil, false
-}
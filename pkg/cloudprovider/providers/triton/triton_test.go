@@ -1,8 +1,20 @@
 package triton
 
 import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
 	"strings"
 	"testing"
+
+	triton "github.com/joyent/triton-go"
+	"golang.org/x/crypto/ssh"
 )
 
 func TestReadConfig(t *testing.T) {
@@ -36,3 +48,102 @@ account = testuser
 		t.Errorf("Should fail when can't match key-id: %s", cfg.Global.KeyID)
 	}
 }
+
+// TestNewTritonPropagatesMetadataError exercises newTriton against a fake
+// MetadataSource, confirming a metadata lookup failure is returned to the
+// caller (rather than papered over, e.g. by falling back to UUID-as-
+// hostname) and that it happens before any CloudAPI request is attempted --
+// so this runs without root, a SmartOS zone, or network access.
+func TestNewTritonPropagatesMetadataError(t *testing.T) {
+	keyPath, keyID := writeTestKey(t)
+	defer os.Remove(keyPath)
+
+	var cfg Config
+	cfg.Global.KeyID = keyID
+	cfg.Global.KeyPath = keyPath
+	cfg.Global.EndpointURL = "https://us-sw-1.api.joyent.com"
+	cfg.Global.AccountName = "testuser"
+
+	wantErr := errors.New("mdata-get: no such binary")
+	source := &fakeMetadataSource{err: wantErr}
+
+	lookup := func(client *triton.Client, uuid string) (*triton.Machine, error) {
+		t.Fatalf("lookupLocalhost should not be called once the metadata lookup has failed")
+		return nil, nil
+	}
+
+	_, err := newTriton(cfg, source, lookup)
+	if err == nil {
+		t.Fatalf("newTriton() should fail when the MetadataSource returns an error")
+	}
+	if !strings.Contains(err.Error(), wantErr.Error()) {
+		t.Errorf("newTriton() error = %q, want it to carry the MetadataSource error %q", err, wantErr)
+	}
+}
+
+// TestNewTritonPropagatesLocalhostLookupError exercises newTriton against a
+// fake MetadataSource that succeeds and a fake localhostLookup that fails,
+// confirming the lookup error is returned to the caller rather than crashing
+// the process via log.Fatalf -- so a broken CloudAPI connection fails a
+// single newTriton call instead of the whole kubelet/controller-manager.
+func TestNewTritonPropagatesLocalhostLookupError(t *testing.T) {
+	keyPath, keyID := writeTestKey(t)
+	defer os.Remove(keyPath)
+
+	var cfg Config
+	cfg.Global.KeyID = keyID
+	cfg.Global.KeyPath = keyPath
+	cfg.Global.EndpointURL = "https://us-sw-1.api.joyent.com"
+	cfg.Global.AccountName = "testuser"
+
+	source := &fakeMetadataSource{metadata: &Metadata{
+		UUID:       "test-uuid",
+		Hostname:   "test-host",
+		Datacenter: "test-dc",
+	}}
+
+	wantErr := errors.New("no such machine")
+	lookup := func(client *triton.Client, uuid string) (*triton.Machine, error) {
+		return nil, wantErr
+	}
+
+	_, err := newTriton(cfg, source, lookup)
+	if err == nil {
+		t.Fatalf("newTriton() should fail cleanly when lookupLocalhost returns an error")
+	}
+	if !strings.Contains(err.Error(), wantErr.Error()) {
+		t.Errorf("newTriton() error = %q, want it to carry the lookupLocalhost error %q", err, wantErr)
+	}
+}
+
+// writeTestKey writes a freshly generated RSA private key to a temp file and
+// returns its path and its MD5 key ID, for tests that need a KeyPath and
+// KeyID signerFromConfig will accept.
+func writeTestKey(t *testing.T) (string, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("could not generate test key: %s", err)
+	}
+
+	f, err := ioutil.TempFile("", "triton-test-key")
+	if err != nil {
+		t.Fatalf("could not create temp file: %s", err)
+	}
+	defer f.Close()
+
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	if err := pem.Encode(f, block); err != nil {
+		t.Fatalf("could not encode test key: %s", err)
+	}
+
+	pub, err := ssh.NewPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("could not derive test public key: %s", err)
+	}
+	sum := md5.Sum(pub.Marshal())
+	octets := make([]string, len(sum))
+	for i, b := range sum {
+		octets[i] = fmt.Sprintf("%02x", b)
+	}
+	return f.Name(), strings.Join(octets, ":")
+}
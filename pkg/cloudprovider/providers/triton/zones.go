@@ -0,0 +1,73 @@
+package triton
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+	triton "github.com/joyent/triton-go"
+	"k8s.io/kubernetes/pkg/cloudprovider"
+	"k8s.io/kubernetes/pkg/types"
+)
+
+// Zones implements cloudprovider.Zones on top of Triton datacenter and
+// compute-node (physical server) placement metadata.
+type Zones struct {
+	provider *Triton
+}
+
+// Zones returns an implementation of cloudprovider.Zones for Triton.
+func (t *Triton) Zones() (cloudprovider.Zones, bool) {
+	glog.V(2).Info("Triton.Zones() called")
+
+	return &Zones{
+		provider: t,
+	}, true
+}
+
+// zoneForMachine maps a triton.Machine to the cloudprovider.Zone it runs in:
+// the UUID of the compute node (physical server) it is placed on as
+// FailureDomain, and the datacenter of the CloudAPI endpoint we're talking to
+// as Region.
+func (z *Zones) zoneForMachine(machine *triton.Machine) cloudprovider.Zone {
+	return cloudprovider.Zone{
+		FailureDomain: machine.ComputeNode,
+		Region:        z.provider.Metadata.Datacenter,
+	}
+}
+
+// GetZone returns the Zone containing the current node.
+func (z *Zones) GetZone() (cloudprovider.Zone, error) {
+	instances := Instances{provider: z.provider}
+	machine, err := instances.getMachineByUUID(z.provider.Metadata.UUID)
+	if err != nil {
+		return cloudprovider.Zone{}, err
+	}
+	return z.zoneForMachine(machine), nil
+}
+
+// GetZoneByProviderID returns the Zone for the node with the given provider
+// ID, which must be of the form "triton://<uuid>".
+func (z *Zones) GetZoneByProviderID(providerID string) (cloudprovider.Zone, error) {
+	if !strings.HasPrefix(providerID, providerIDPrefix) {
+		return cloudprovider.Zone{}, fmt.Errorf("GetZoneByProviderID: malformed provider ID %q, expected prefix %q", providerID, providerIDPrefix)
+	}
+	uuid := strings.TrimPrefix(providerID, providerIDPrefix)
+
+	instances := Instances{provider: z.provider}
+	machine, err := instances.getMachineByUUID(uuid)
+	if err != nil {
+		return cloudprovider.Zone{}, err
+	}
+	return z.zoneForMachine(machine), nil
+}
+
+// GetZoneByNodeName returns the Zone for the node with the given name.
+func (z *Zones) GetZoneByNodeName(name types.NodeName) (cloudprovider.Zone, error) {
+	instances := Instances{provider: z.provider}
+	machine, err := instances.getMachineByName(string(name))
+	if err != nil {
+		return cloudprovider.Zone{}, err
+	}
+	return z.zoneForMachine(machine), nil
+}
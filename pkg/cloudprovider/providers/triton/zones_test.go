@@ -0,0 +1,39 @@
+package triton
+
+import (
+	"testing"
+
+	triton "github.com/joyent/triton-go"
+)
+
+func TestZoneForMachine(t *testing.T) {
+	z := &Zones{provider: &Triton{Metadata: &Metadata{Datacenter: "us-sw-1"}}}
+	machine := &triton.Machine{ComputeNode: "44454c4c-5000-1047-8051-b3c04f435831"}
+
+	zone := z.zoneForMachine(machine)
+	if zone.FailureDomain != machine.ComputeNode {
+		t.Errorf("zoneForMachine().FailureDomain = %q, want %q", zone.FailureDomain, machine.ComputeNode)
+	}
+	if zone.Region != "us-sw-1" {
+		t.Errorf("zoneForMachine().Region = %q, want %q", zone.Region, "us-sw-1")
+	}
+}
+
+// TestGetZoneByProviderIDMalformed exercises GetZoneByProviderID's prefix
+// parsing, which must reject anything not of the form "triton://<uuid>"
+// before ever touching the machine cache or CloudAPI.
+func TestGetZoneByProviderIDMalformed(t *testing.T) {
+	tests := []string{
+		"",
+		"44454c4c-5000-1047-8051-b3c04f435831",
+		"aws:///us-east-1a/i-abcdef",
+		"trITon://44454c4c-5000-1047-8051-b3c04f435831",
+	}
+
+	z := &Zones{provider: &Triton{}}
+	for _, providerID := range tests {
+		if _, err := z.GetZoneByProviderID(providerID); err == nil {
+			t.Errorf("GetZoneByProviderID(%q) should fail for a provider ID missing the %q prefix", providerID, providerIDPrefix)
+		}
+	}
+}